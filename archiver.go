@@ -0,0 +1,296 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package unpackit
+
+import (
+	"archive/tar"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ChownOpts overrides the owner and group that would otherwise come from the
+// tar header, mirroring the -o/-g behavior of docker's archive package.
+type ChownOpts struct {
+	UID int
+	GID int
+}
+
+// TarOptions configures how an Archiver extracts a TAR stream.
+type TarOptions struct {
+	// NoLchown skips chowning symlinks, regular files and hardlinks
+	// entirely, leaving them owned by whichever user the extracting
+	// process ran as. Defaults to false.
+	NoLchown bool
+
+	// PreserveOwners applies the owner/group recorded in the tar header to
+	// every extracted entry. Without it, and unless NoLchown is set, every
+	// entry is instead chowned to the calling user's own uid/gid — this is
+	// the default, since archives commonly carry owners that don't exist on
+	// the extracting machine. Ignored when NoLchown is set.
+	PreserveOwners bool
+
+	// ChownOpts, when set, overrides the owner/group of every extracted
+	// entry instead of using the one recorded in the tar header.
+	ChownOpts *ChownOpts
+
+	// IncludePatterns restricts extraction to entries whose name matches
+	// at least one of these filepath.Match-style patterns. An empty list
+	// includes everything.
+	IncludePatterns []string
+
+	// ExcludePatterns skips entries whose name matches any of these
+	// filepath.Match-style patterns. Evaluated after IncludePatterns.
+	ExcludePatterns []string
+}
+
+// Archiver unarchives TAR streams according to its Options. The zero value,
+// or NewArchiver(nil), is a plain Archiver with no filtering and default
+// ownership handling, equivalent to what Untar used to do unconditionally.
+type Archiver struct {
+	Options *TarOptions
+}
+
+// NewArchiver builds an Archiver with the given options. A nil options value
+// is replaced with the zero-value TarOptions.
+func NewArchiver(options *TarOptions) *Archiver {
+	if options == nil {
+		options = &TarOptions{}
+	}
+	return &Archiver{Options: options}
+}
+
+// Untar unarchives a TAR archive and returns the final destination path or an
+// error. It is a convenience wrapper around NewArchiver(nil).Untar.
+func Untar(data io.Reader, destPath string) (string, error) {
+	return NewArchiver(nil).Untar(data, destPath)
+}
+
+// Untar unarchives a TAR archive honoring a.Options and returns the final
+// destination path or an error.
+//
+// Besides regular files and directories, it understands symlinks, hardlinks
+// and device/FIFO nodes (tar.TypeSymlink, TypeLink, TypeChar, TypeBlock and
+// TypeFifo). PAX and GNU long name/long-link records are handled transparently
+// by archive/tar itself, so hdr.Name and hdr.Linkname are already the fully
+// resolved names by the time they reach this loop.
+func (a *Archiver) Untar(data io.Reader, destPath string) (string, error) {
+	// Makes sure destPath exists
+	if err := os.MkdirAll(destPath, 0740); err != nil {
+		return "", err
+	}
+
+	tr := tar.NewReader(data)
+	tracker := newExtractionTracker(destPath)
+
+	// Iterate through the files in the archive.
+	rootdir := destPath
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			// end of tar archive
+			break
+		}
+
+		if err != nil {
+			return rootdir, err
+		}
+
+		// Skip pax_global_header with the commit ID this archive was created from
+		if hdr.Name == "pax_global_header" {
+			continue
+		}
+
+		if !a.matches(hdr.Name) {
+			continue
+		}
+
+		fp, err := tracker.resolve(hdr.Name)
+		if err != nil {
+			return rootdir, err
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if rootdir == destPath {
+				rootdir = fp
+			}
+
+			if err := os.MkdirAll(fp, os.FileMode(hdr.Mode)); err != nil {
+				return rootdir, err
+			}
+		case tar.TypeSymlink:
+			if err := a.untarSymlink(tracker, hdr, fp); err != nil {
+				return rootdir, err
+			}
+		case tar.TypeLink:
+			if err := a.untarHardlink(tracker, hdr, fp); err != nil {
+				return rootdir, err
+			}
+		case tar.TypeChar, tar.TypeBlock, tar.TypeFifo:
+			if err := a.untarSpecial(hdr, fp); err != nil {
+				return rootdir, err
+			}
+		default:
+			if _, err := untarFile(a.Options, hdr, tr, fp, rootdir); err != nil {
+				return rootdir, err
+			}
+		}
+	}
+
+	return rootdir, nil
+}
+
+// matches reports whether name should be extracted given a's include/exclude
+// patterns. Exclude patterns take precedence over include patterns.
+func (a *Archiver) matches(name string) bool {
+	if len(a.Options.IncludePatterns) > 0 && !matchAny(a.Options.IncludePatterns, name) {
+		return false
+	}
+
+	if matchAny(a.Options.ExcludePatterns, name) {
+		return false
+	}
+
+	return true
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, err := filepath.Match(pattern, name); err == nil && ok {
+			return true
+		}
+	}
+	return false
+}
+
+// chownFile applies the owner/group recorded in hdr to name, honoring opts.
+// Failures are logged and otherwise ignored, since unprivileged extraction
+// commonly can't chown to arbitrary owners.
+func chownFile(opts *TarOptions, name string, hdr *tar.Header) {
+	if opts.NoLchown {
+		return
+	}
+
+	uid, gid := hdr.Uid, hdr.Gid
+	if opts.ChownOpts != nil {
+		uid, gid = opts.ChownOpts.UID, opts.ChownOpts.GID
+	} else if !opts.PreserveOwners {
+		uid, gid = os.Getuid(), os.Getgid()
+	}
+
+	if err := os.Lchown(name, uid, gid); err != nil {
+		log.Printf("warn: failed setting owner for %q: %#v", name, err)
+	}
+}
+
+func untarFile(opts *TarOptions, hdr *tar.Header, tr *tar.Reader, fp, rootdir string) (string, error) {
+	parentDir, _ := filepath.Split(fp)
+
+	if err := os.MkdirAll(parentDir, 0740); err != nil {
+		return rootdir, err
+	}
+
+	file, err := os.Create(fp)
+	if err != nil {
+		return rootdir, err
+	}
+
+	defer func() {
+		if err := file.Close(); err != nil {
+			log.Println(err)
+		}
+	}()
+
+	if err := file.Chmod(os.FileMode(hdr.Mode)); err != nil {
+		log.Printf("warn: failed setting file permissions for %q: %#v", file.Name(), err)
+	}
+
+	chownFile(opts, file.Name(), hdr)
+
+	if err := os.Chtimes(file.Name(), time.Now(), hdr.ModTime); err != nil {
+		log.Printf("warn: failed setting file atime and mtime for %q: %#v", file.Name(), err)
+	}
+
+	if _, err := io.Copy(file, tr); err != nil {
+		return rootdir, err
+	}
+
+	return rootdir, nil
+}
+
+// untarSymlink recreates a symlink entry. hdr.Linkname goes through the same
+// escape checks as hdr.Name, so a link target of ../../etc or an absolute
+// path cannot escape destPath. The entry is also recorded in tracker so that
+// later entries can't write through it.
+func (a *Archiver) untarSymlink(tracker *extractionTracker, hdr *tar.Header, fp string) error {
+	parentDir, _ := filepath.Split(fp)
+	if err := os.MkdirAll(parentDir, 0740); err != nil {
+		return err
+	}
+
+	linkname, err := tracker.linkTarget(hdr.Name, hdr.Linkname)
+	if err != nil {
+		return err
+	}
+
+	if err := os.RemoveAll(fp); err != nil {
+		return err
+	}
+
+	if err := os.Symlink(linkname, fp); err != nil {
+		return err
+	}
+
+	chownFile(a.Options, fp, hdr)
+
+	tracker.markSymlink(hdr.Name)
+	return nil
+}
+
+// untarHardlink recreates a hardlink entry. hdr.Linkname is resolved through
+// the same tracker every other entry is, so it can neither escape destPath
+// nor point through a previously extracted symlink.
+func (a *Archiver) untarHardlink(tracker *extractionTracker, hdr *tar.Header, fp string) error {
+	parentDir, _ := filepath.Split(fp)
+	if err := os.MkdirAll(parentDir, 0740); err != nil {
+		return err
+	}
+
+	target, err := tracker.resolve(hdr.Linkname)
+	if err != nil {
+		return err
+	}
+
+	if err := os.RemoveAll(fp); err != nil {
+		return err
+	}
+
+	if err := os.Link(target, fp); err != nil {
+		return err
+	}
+
+	chownFile(a.Options, fp, hdr)
+
+	return nil
+}
+
+// untarSpecial recreates device and FIFO nodes. Since mknod(2) is
+// platform-specific and usually requires privileges, unsupported platforms or
+// permission failures are logged and skipped instead of aborting the unpack.
+func (a *Archiver) untarSpecial(hdr *tar.Header, fp string) error {
+	parentDir, _ := filepath.Split(fp)
+	if err := os.MkdirAll(parentDir, 0740); err != nil {
+		return err
+	}
+
+	if err := mknod(fp, hdr); err != nil {
+		log.Printf("warn: failed creating device node %q: %#v", fp, err)
+	}
+
+	return nil
+}
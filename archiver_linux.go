@@ -0,0 +1,35 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package unpackit
+
+import (
+	"archive/tar"
+	"syscall"
+)
+
+// mknod recreates a device or FIFO entry using the major/minor numbers
+// recorded in hdr. Only implemented on Linux, where syscall.Mknod and
+// syscall.Mkdev are available.
+func mknod(fp string, hdr *tar.Header) error {
+	var mode uint32
+	switch hdr.Typeflag {
+	case tar.TypeChar:
+		mode = syscall.S_IFCHR
+	case tar.TypeBlock:
+		mode = syscall.S_IFBLK
+	case tar.TypeFifo:
+		mode = syscall.S_IFIFO
+	}
+
+	dev := mkdev(hdr.Devmajor, hdr.Devminor)
+	return syscall.Mknod(fp, mode|uint32(hdr.Mode), int(dev))
+}
+
+// mkdev encodes major/minor device numbers the same way glibc's
+// gnu_dev_makedev does; syscall.Mkdev isn't available on every Linux arch.
+func mkdev(major, minor int64) uint64 {
+	return uint64((minor & 0xff) | ((major & 0xfff) << 8) |
+		((minor &^ 0xff) << 12) | ((major &^ 0xfff) << 32))
+}
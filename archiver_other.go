@@ -0,0 +1,20 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+//go:build !linux
+// +build !linux
+
+package unpackit
+
+import (
+	"archive/tar"
+	"fmt"
+	"runtime"
+)
+
+// mknod is not implemented outside Linux; device and FIFO entries are
+// skipped with a warning on those platforms.
+func mknod(fp string, hdr *tar.Header) error {
+	return fmt.Errorf("unpackit: device/FIFO extraction is not supported on %s", runtime.GOOS)
+}
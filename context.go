@@ -0,0 +1,404 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package unpackit
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+)
+
+var errUnpackNilFile = errors.New("You must provide a valid file to unpack")
+
+// ProgressFunc is called as entries are extracted and, for large entries,
+// periodically as their data is copied. bytesTotal is the size recorded in
+// the archive for that entry, or -1 when it isn't known upfront (e.g. a
+// streamed ZIP entry with a trailing data descriptor).
+type ProgressFunc func(entry string, bytesDone, bytesTotal int64)
+
+// Option configures the Context-aware unpacking entry points.
+type Option func(*unpackConfig)
+
+type unpackConfig struct {
+	progress ProgressFunc
+}
+
+// WithProgress registers a ProgressFunc invoked as entries are extracted.
+func WithProgress(fn ProgressFunc) Option {
+	return func(c *unpackConfig) {
+		c.progress = fn
+	}
+}
+
+func newUnpackConfig(opts []Option) *unpackConfig {
+	cfg := &unpackConfig{}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	return cfg
+}
+
+func (c *unpackConfig) report(entry string, bytesDone, bytesTotal int64) {
+	if c.progress != nil {
+		c.progress(entry, bytesDone, bytesTotal)
+	}
+}
+
+// contextReader wraps an io.Reader so every Read aborts as soon as ctx is
+// done, and reports progress for the entry being copied.
+type contextReader struct {
+	ctx        context.Context
+	r          io.Reader
+	cfg        *unpackConfig
+	name       string
+	bytesDone  int64
+	bytesTotal int64
+}
+
+func (cr *contextReader) Read(p []byte) (int, error) {
+	if err := cr.ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	n, err := cr.r.Read(p)
+	if n > 0 {
+		cr.bytesDone += int64(n)
+		cr.cfg.report(cr.name, cr.bytesDone, cr.bytesTotal)
+	}
+
+	return n, err
+}
+
+// UnpackContext behaves like Unpack, but aborts as soon as ctx is done and
+// reports extraction progress through any WithProgress option passed in.
+func UnpackContext(ctx context.Context, file *os.File, destPath string, opts ...Option) (string, error) {
+	if file == nil {
+		return "", errUnpackNilFile
+	}
+
+	var err error
+	if destPath == "" {
+		destPath, err = ioutil.TempDir(os.TempDir(), "unpackit-")
+		if err != nil {
+			return "", err
+		}
+	}
+
+	if err := os.MkdirAll(destPath, 0740); err != nil {
+		return "", err
+	}
+
+	return UnpackStreamContext(ctx, file, destPath, opts...)
+}
+
+// UnpackStreamContext behaves like UnpackStream, but aborts as soon as ctx is
+// done and reports extraction progress through any WithProgress option
+// passed in.
+func UnpackStreamContext(ctx context.Context, reader io.Reader, destPath string, opts ...Option) (string, error) {
+	r := bufio.NewReader(reader)
+
+	ftype, err := magicNumber(r, 0)
+	if err != nil {
+		return "", err
+	}
+
+	var decompressingReader *bufio.Reader
+	switch ftype {
+	case "gzip":
+		decompressingReader, err = NewUnpacker(&UnpackerOptions{ParallelGzip: UseParallelGzip}).GunzipStream(r)
+		if err != nil {
+			return "", err
+		}
+	case "xz":
+		decompressingReader, err = UnxzStream(r)
+		if err != nil {
+			return "", err
+		}
+	case "bzip":
+		decompressingReader, err = Bunzip2Stream(r)
+		if err != nil {
+			return "", err
+		}
+	case "zstd":
+		decompressingReader, err = UnzstdStream(r)
+		if err != nil {
+			return "", err
+		}
+	case "lz4":
+		decompressingReader, err = Unlz4Stream(r)
+		if err != nil {
+			return "", err
+		}
+	case "zip":
+		return UnzipContext(ctx, r, destPath, opts...)
+	default:
+		decompressingReader = r
+	}
+
+	ftype, err = magicNumber(decompressingReader, 257)
+	if err != nil {
+		return "", err
+	}
+	if ftype == "tar" {
+		return UntarContext(ctx, decompressingReader, destPath, opts...)
+	}
+
+	cfg := newUnpackConfig(opts)
+	destRawFile := filepath.Join(destPath, path.Base("unknown-pack"))
+
+	destFile, err := os.Create(destRawFile)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if err := destFile.Close(); err != nil {
+			log.Println(err)
+		}
+	}()
+
+	cr := &contextReader{ctx: ctx, r: decompressingReader, cfg: cfg, name: destRawFile, bytesTotal: -1}
+	if _, err := io.Copy(destFile, cr); err != nil {
+		return "", err
+	}
+
+	return destPath, nil
+}
+
+// UntarContext behaves like Untar, but aborts as soon as ctx is done,
+// closing the file it was writing to, and reports extraction progress
+// through any WithProgress option passed in.
+func UntarContext(ctx context.Context, data io.Reader, destPath string, opts ...Option) (string, error) {
+	cfg := newUnpackConfig(opts)
+	archiver := NewArchiver(nil)
+
+	if err := os.MkdirAll(destPath, 0740); err != nil {
+		return "", err
+	}
+
+	tr := tar.NewReader(data)
+	tracker := newExtractionTracker(destPath)
+
+	rootdir := destPath
+	for {
+		if err := ctx.Err(); err != nil {
+			return rootdir, err
+		}
+
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return rootdir, err
+		}
+
+		if hdr.Name == "pax_global_header" {
+			continue
+		}
+
+		if !archiver.matches(hdr.Name) {
+			continue
+		}
+
+		fp, err := tracker.resolve(hdr.Name)
+		if err != nil {
+			return rootdir, err
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if rootdir == destPath {
+				rootdir = fp
+			}
+			if err := os.MkdirAll(fp, os.FileMode(hdr.Mode)); err != nil {
+				return rootdir, err
+			}
+		case tar.TypeSymlink:
+			if err := archiver.untarSymlink(tracker, hdr, fp); err != nil {
+				return rootdir, err
+			}
+		case tar.TypeLink:
+			if err := archiver.untarHardlink(tracker, hdr, fp); err != nil {
+				return rootdir, err
+			}
+		case tar.TypeChar, tar.TypeBlock, tar.TypeFifo:
+			if err := archiver.untarSpecial(hdr, fp); err != nil {
+				return rootdir, err
+			}
+		default:
+			if err := untarFileContext(ctx, cfg, archiver.Options, hdr, tr, fp); err != nil {
+				return rootdir, err
+			}
+		}
+
+		cfg.report(hdr.Name, hdr.Size, hdr.Size)
+	}
+
+	return rootdir, nil
+}
+
+func untarFileContext(ctx context.Context, cfg *unpackConfig, tarOpts *TarOptions, hdr *tar.Header, tr *tar.Reader, fp string) error {
+	parentDir, _ := filepath.Split(fp)
+	if err := os.MkdirAll(parentDir, 0740); err != nil {
+		return err
+	}
+
+	file, err := os.Create(fp)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := file.Close(); err != nil {
+			log.Println(err)
+		}
+	}()
+
+	if err := file.Chmod(os.FileMode(hdr.Mode)); err != nil {
+		log.Printf("warn: failed setting file permissions for %q: %#v", file.Name(), err)
+	}
+
+	chownFile(tarOpts, file.Name(), hdr)
+
+	if err := os.Chtimes(file.Name(), time.Now(), hdr.ModTime); err != nil {
+		log.Printf("warn: failed setting file atime and mtime for %q: %#v", file.Name(), err)
+	}
+
+	cr := &contextReader{ctx: ctx, r: tr, cfg: cfg, name: hdr.Name, bytesTotal: hdr.Size}
+	if _, err := io.Copy(file, cr); err != nil {
+		// Remove the partially-written file rather than leaving a truncated
+		// entry behind when extraction is cancelled mid-copy.
+		os.Remove(fp)
+		return err
+	}
+
+	return nil
+}
+
+// UnzipContext behaves like UnzipStream, but aborts as soon as ctx is done,
+// closing the file it was writing to, and reports extraction progress
+// through any WithProgress option passed in.
+func UnzipContext(ctx context.Context, r io.Reader, destPath string, opts ...Option) (string, error) {
+	cfg := newUnpackConfig(opts)
+	tracker := newExtractionTracker(destPath)
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return destPath, err
+		}
+
+		sig, err := readUint32(r)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return destPath, err
+		}
+
+		if sig != localFileHeaderSignature {
+			break
+		}
+
+		hdr, err := readZipLocalHeader(r)
+		if err != nil {
+			return destPath, err
+		}
+
+		if err := unzipStreamEntryContext(ctx, cfg, tracker, r, hdr); err != nil {
+			return destPath, err
+		}
+	}
+
+	return destPath, nil
+}
+
+func unzipStreamEntryContext(ctx context.Context, cfg *unpackConfig, tracker *extractionTracker, r io.Reader, hdr *zipLocalHeader) error {
+	filePath, err := tracker.resolve(hdr.name)
+	if err != nil {
+		return err
+	}
+
+	if isZipDirEntry(hdr.name) {
+		return os.MkdirAll(filePath, 0750)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(filePath), 0750); err != nil {
+		return err
+	}
+
+	dcomp, err := decompressor(hdr.method)
+	if err != nil {
+		return err
+	}
+
+	if hdr.method == zip.Store && hdr.hasDataDescriptor {
+		// See the identical check in unzipStreamEntry: Store has no framing
+		// of its own, so without a declared size there's no safe way to know
+		// where this entry's data ends while streaming.
+		return fmt.Errorf("unpackit: zip entry %q uses Store compression with a streamed data descriptor; its size isn't known upfront and can't be determined while streaming, use Unzip on a seekable file instead", hdr.name)
+	}
+
+	var compressed io.Reader = r
+	bytesTotal := hdr.compressedSize
+	if !hdr.hasDataDescriptor {
+		compressed = io.LimitReader(r, hdr.compressedSize)
+	} else {
+		bytesTotal = -1
+	}
+
+	rc := dcomp(compressed)
+	defer func() {
+		if err := rc.Close(); err != nil {
+			log.Println(err)
+		}
+	}()
+
+	file, err := os.Create(filePath)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := file.Close(); err != nil {
+			log.Println(err)
+		}
+	}()
+
+	cr := &contextReader{ctx: ctx, r: rc, cfg: cfg, name: hdr.name, bytesTotal: bytesTotal}
+	if _, err := io.Copy(file, cr); err != nil {
+		os.Remove(filePath)
+		return err
+	}
+
+	if err := os.Chtimes(filePath, time.Now(), hdr.modified); err != nil {
+		log.Printf("warn: failed setting file atime and mtime for %q: %#v", filePath, err)
+	}
+
+	if !hdr.hasDataDescriptor {
+		// hdr.uncompressedSize is known upfront here, and the contextReader
+		// has already reported it as the copy's final bytesDone, so this is
+		// just a clean final confirmation call. When a data descriptor is
+		// present, hdr.uncompressedSize is always 0 in the local header (the
+		// real size only shows up in the descriptor we haven't read yet), so
+		// reporting it here would make progress jump backwards to zero right
+		// as the entry finishes; the contextReader's own final report during
+		// the copy is already the real, accurate value.
+		cfg.report(hdr.name, hdr.uncompressedSize, hdr.uncompressedSize)
+	}
+
+	if hdr.hasDataDescriptor {
+		return skipDataDescriptor(r, hdr.isZip64)
+	}
+
+	return nil
+}
@@ -0,0 +1,113 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package unpackit
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hooklift/assert"
+)
+
+func TestUntarContextProgress(t *testing.T) {
+	buf := new(bytes.Buffer)
+	tw := tar.NewWriter(buf)
+
+	body := []byte("hello from the context-aware untar path")
+	assert.Ok(t, tw.WriteHeader(&tar.Header{
+		Name: "greeting.txt",
+		Size: int64(len(body)),
+	}))
+	_, err := tw.Write(body)
+	assert.Ok(t, err)
+	assert.Ok(t, tw.Close())
+
+	destDir, err := ioutil.TempDir(os.TempDir(), "unpackit-untar-context")
+	assert.Ok(t, err)
+	defer os.RemoveAll(destDir)
+
+	var lastDone, lastTotal int64
+	var calls int
+	progress := func(entry string, bytesDone, bytesTotal int64) {
+		calls++
+		lastDone, lastTotal = bytesDone, bytesTotal
+	}
+
+	_, err = UntarContext(context.Background(), bytes.NewReader(buf.Bytes()), destDir, WithProgress(progress))
+	assert.Ok(t, err)
+
+	assert.Cond(t, calls > 0, "expected progress callback to be invoked")
+	assert.Equals(t, int64(len(body)), lastDone)
+	assert.Equals(t, int64(len(body)), lastTotal)
+}
+
+func TestUntarContextCancellation(t *testing.T) {
+	buf := new(bytes.Buffer)
+	tw := tar.NewWriter(buf)
+
+	body := bytes.Repeat([]byte("x"), 1<<20)
+	assert.Ok(t, tw.WriteHeader(&tar.Header{
+		Name: "big.bin",
+		Size: int64(len(body)),
+	}))
+	_, err := tw.Write(body)
+	assert.Ok(t, err)
+	assert.Ok(t, tw.Close())
+
+	destDir, err := ioutil.TempDir(os.TempDir(), "unpackit-untar-cancel")
+	assert.Ok(t, err)
+	defer os.RemoveAll(destDir)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err = UntarContext(ctx, bytes.NewReader(buf.Bytes()), destDir)
+	assert.Cond(t, err == context.Canceled, "expected context.Canceled, got %v", err)
+}
+
+func TestUnzipContextProgress(t *testing.T) {
+	buf := new(bytes.Buffer)
+	zw := zip.NewWriter(buf)
+
+	body := []byte("hello from the context-aware unzip path")
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: "greeting.txt", Method: zip.Deflate})
+	assert.Ok(t, err)
+	_, err = w.Write(body)
+	assert.Ok(t, err)
+	assert.Ok(t, zw.Close())
+
+	destDir, err := ioutil.TempDir(os.TempDir(), "unpackit-unzip-context")
+	assert.Ok(t, err)
+	defer os.RemoveAll(destDir)
+
+	var calls int
+	var lastDone, lastTotal int64
+	progress := func(entry string, bytesDone, bytesTotal int64) {
+		calls++
+		lastDone, lastTotal = bytesDone, bytesTotal
+	}
+
+	// zip.Writer always sets the data-descriptor flag, regardless of method,
+	// so greeting.txt's uncompressedSize is 0 in its local header: the real
+	// size only shows up in the trailing descriptor. The last progress call
+	// must still reflect the real bytes copied, not the local header's zeroed
+	// field, or progress would jump backwards to zero right as the entry
+	// finishes.
+	_, err = UnzipContext(context.Background(), bytes.NewReader(buf.Bytes()), destDir, WithProgress(progress))
+	assert.Ok(t, err)
+	assert.Cond(t, calls > 0, "expected progress callback to be invoked")
+	assert.Equals(t, int64(len(body)), lastDone)
+	assert.Equals(t, int64(-1), lastTotal)
+
+	got, err := ioutil.ReadFile(filepath.Join(destDir, "greeting.txt"))
+	assert.Ok(t, err)
+	assert.Equals(t, string(body), string(got))
+}
@@ -0,0 +1,152 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package unpackit
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+)
+
+// Compression identifies the compression format of a stream, detected from
+// its leading bytes rather than from a file extension.
+type Compression int
+
+const (
+	// Uncompressed means the content didn't match any known compression
+	// magic number, and presumably isn't compressed at all.
+	Uncompressed Compression = iota
+	// Gzip is the gzip format, magic number 1f 8b.
+	Gzip
+	// Bzip2 is the bzip2 format, magic number 42 5a.
+	Bzip2
+	// Xz is the xz format, magic number fd 37 7a 58 5a 00.
+	Xz
+	// Zip is the pkzip/ZIP format, magic number 50 4b 03 04.
+	Zip
+	// Tar is the POSIX ustar format, magic number 75 73 74 61 72 at offset 257.
+	Tar
+	// Zstd is the Zstandard frame format, magic number 28 b5 2f fd.
+	Zstd
+	// Lz4 is the LZ4 frame format, magic number 04 22 4d 18.
+	Lz4
+	// Brotli is the Brotli stream format. Brotli has no reliable magic
+	// number, so it is never returned by DetectCompression and is only
+	// defined so callers can reference it alongside the other constants.
+	Brotli
+)
+
+var (
+	magicZIP  = []byte{0x50, 0x4b, 0x03, 0x04}
+	magicGZ   = []byte{0x1f, 0x8b}
+	magicBZIP = []byte{0x42, 0x5a}
+	magicXZ   = []byte{0xfd, 0x37, 0x7a, 0x58, 0x5a, 0x00}
+	magicZSTD = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	magicLZ4  = []byte{0x04, 0x22, 0x4d, 0x18}
+
+	// magicTAR holds both the POSIX ("ustar\x00") and GNU ("ustar  \x00")
+	// spellings of the ustar magic, found at offset 257 of a tar header.
+	magicTAR = [][]byte{
+		[]byte("ustar\x00"),
+		[]byte("ustar  \x00"),
+	}
+)
+
+// tarOffset is where the ustar magic lives within a 512-byte tar header.
+const tarOffset = 257
+
+// DetectCompression inspects the leading bytes of header and returns the
+// Compression it recognizes, or Uncompressed if none of the known magic
+// numbers match. header should hold at least 262 bytes when the caller wants
+// tar detection to work, since the ustar magic sits at offset 257.
+func DetectCompression(header []byte) Compression {
+	switch {
+	case hasPrefix(header, magicGZ):
+		return Gzip
+	case hasPrefix(header, magicBZIP):
+		return Bzip2
+	case hasPrefix(header, magicXZ):
+		return Xz
+	case hasPrefix(header, magicZIP):
+		return Zip
+	case hasPrefix(header, magicZSTD):
+		return Zstd
+	case hasPrefix(header, magicLZ4):
+		return Lz4
+	case hasTarMagic(header):
+		return Tar
+	default:
+		return Uncompressed
+	}
+}
+
+// IsArchive reports whether header matches any of the archive or compression
+// formats DetectCompression knows about.
+func IsArchive(header []byte) bool {
+	return DetectCompression(header) != Uncompressed
+}
+
+func hasPrefix(header, magic []byte) bool {
+	return len(header) >= len(magic) && bytes.Equal(header[:len(magic)], magic)
+}
+
+// hasTarMagic reports whether header carries the ustar magic, in either its
+// POSIX or GNU spelling, at offset 257.
+func hasTarMagic(header []byte) bool {
+	if len(header) < tarOffset+8 {
+		return false
+	}
+
+	for _, magic := range magicTAR {
+		if bytes.Equal(header[tarOffset:tarOffset+len(magic)], magic) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// UnzstdStream unpacks a Zstandard stream.
+func UnzstdStream(reader io.Reader) (*bufio.Reader, error) {
+	decompressingReader, err := zstd.NewReader(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	return bufio.NewReader(decompressingReader), nil
+}
+
+// Unlz4Stream unpacks an LZ4 frame stream.
+func Unlz4Stream(reader io.Reader) (*bufio.Reader, error) {
+	return bufio.NewReader(lz4.NewReader(reader)), nil
+}
+
+// compressionName maps a Compression to the string identifiers magicNumber
+// and UnpackStream's switch have historically used.
+func (c Compression) name() string {
+	switch c {
+	case Gzip:
+		return "gzip"
+	case Bzip2:
+		return "bzip"
+	case Xz:
+		return "xz"
+	case Zip:
+		return "zip"
+	case Tar:
+		return "tar"
+	case Zstd:
+		return "zstd"
+	case Lz4:
+		return "lz4"
+	case Brotli:
+		return "brotli"
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,60 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package unpackit
+
+import (
+	"testing"
+
+	"github.com/hooklift/assert"
+)
+
+func TestDetectCompression(t *testing.T) {
+	var tests = []struct {
+		name   string
+		header []byte
+		want   Compression
+	}{
+		{"gzip", []byte{0x1f, 0x8b, 0x08, 0, 0, 0, 0, 0}, Gzip},
+		{"bzip2", []byte{0x42, 0x5a, 0x68, 0, 0, 0, 0, 0}, Bzip2},
+		{"xz", []byte{0xfd, 0x37, 0x7a, 0x58, 0x5a, 0x00, 0, 0}, Xz},
+		{"zip", []byte{0x50, 0x4b, 0x03, 0x04, 0, 0, 0, 0}, Zip},
+		{"zstd", []byte{0x28, 0xb5, 0x2f, 0xfd, 0, 0, 0, 0}, Zstd},
+		{"lz4", []byte{0x04, 0x22, 0x4d, 0x18, 0, 0, 0, 0}, Lz4},
+		{"unknown", []byte{0, 0, 0, 0, 0, 0, 0, 0}, Uncompressed},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equals(t, test.want, DetectCompression(test.header))
+			assert.Equals(t, test.want != Uncompressed, IsArchive(test.header))
+		})
+	}
+}
+
+func TestDetectCompressionTarMagic(t *testing.T) {
+	var tests = []struct {
+		name   string
+		magic  []byte
+		wantOk bool
+	}{
+		{"posix ustar", []byte("ustar\x00"), true},
+		{"gnu ustar", []byte("ustar  \x00"), true},
+		{"garbage", []byte("notustar"), false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			header := make([]byte, tarOffset+8)
+			copy(header[tarOffset:], test.magic)
+
+			got := DetectCompression(header)
+			if test.wantOk {
+				assert.Equals(t, Tar, got)
+			} else {
+				assert.Equals(t, Uncompressed, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,68 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package unpackit
+
+import (
+	"bufio"
+	"io"
+
+	"github.com/klauspost/pgzip"
+)
+
+// UseParallelGzip switches GunzipStream's caller, UnpackStream, to pgzip
+// instead of compress/gzip. It's off by default: pgzip only pays for itself
+// on multi-core machines unpacking large tarballs, and adds a goroutine pool
+// that plain gzip doesn't need. Prefer NewUnpacker for call sites that want
+// the behavior without touching package state.
+var UseParallelGzip = false
+
+// parallelGzipMinSize is the smallest input, in bytes, worth handing to
+// pgzip. Below it the goroutine/channel overhead of splitting work across
+// workers outweighs any gain, so we fall back to compress/gzip.
+const parallelGzipMinSize = 4 * 1024 * 1024
+
+// UnpackerOptions configures an Unpacker.
+type UnpackerOptions struct {
+	// ParallelGzip enables the pgzip fast path for gzip streams that are at
+	// least parallelGzipMinSize bytes. Smaller streams, and any stream pgzip
+	// fails to initialize on, are decompressed with compress/gzip instead.
+	ParallelGzip bool
+}
+
+// Unpacker decompresses streams according to its Options. The zero value, or
+// NewUnpacker(nil), behaves exactly like the package-level GunzipStream.
+type Unpacker struct {
+	Options *UnpackerOptions
+}
+
+// NewUnpacker builds an Unpacker with the given options. A nil options value
+// is replaced with the zero-value UnpackerOptions.
+func NewUnpacker(options *UnpackerOptions) *Unpacker {
+	if options == nil {
+		options = &UnpackerOptions{}
+	}
+	return &Unpacker{Options: options}
+}
+
+// GunzipStream unpacks a gzipped stream, using pgzip when u.Options.ParallelGzip
+// is set and the stream is large enough to benefit from it.
+func (u *Unpacker) GunzipStream(reader io.Reader) (*bufio.Reader, error) {
+	if !u.Options.ParallelGzip {
+		return GunzipStream(reader)
+	}
+
+	br := bufio.NewReaderSize(reader, parallelGzipMinSize+1)
+	peeked, _ := br.Peek(parallelGzipMinSize)
+	if len(peeked) < parallelGzipMinSize {
+		return GunzipStream(br)
+	}
+
+	decompressingReader, err := pgzip.NewReader(br)
+	if err != nil {
+		return GunzipStream(br)
+	}
+
+	return bufio.NewReader(decompressingReader), nil
+}
@@ -0,0 +1,67 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package unpackit
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+// gzippedFixture builds a gzip stream of roughly size bytes of compressible
+// tar-like content, large enough to exercise pgzip's block splitting.
+func gzippedFixture(b *testing.B, size int) []byte {
+	b.Helper()
+
+	var raw bytes.Buffer
+	chunk := bytes.Repeat([]byte("unpackit benchmark fixture data\n"), 1024)
+	for raw.Len() < size {
+		raw.Write(chunk)
+	}
+
+	var gz bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	if _, err := w.Write(raw.Bytes()); err != nil {
+		b.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		b.Fatal(err)
+	}
+
+	return gz.Bytes()
+}
+
+func BenchmarkGunzipSerial(b *testing.B) {
+	data := gzippedFixture(b, 256*1024*1024)
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		r, err := GunzipStream(bytes.NewReader(data))
+		if err != nil {
+			b.Fatal(err)
+		}
+		if _, err := io.Copy(ioutil.Discard, r); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkGunzipParallel(b *testing.B) {
+	data := gzippedFixture(b, 256*1024*1024)
+	unpacker := NewUnpacker(&UnpackerOptions{ParallelGzip: true})
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		r, err := unpacker.GunzipStream(bytes.NewReader(data))
+		if err != nil {
+			b.Fatal(err)
+		}
+		if _, err := io.Copy(ioutil.Discard, r); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,30 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package unpackit
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"testing"
+
+	"github.com/hooklift/assert"
+)
+
+func TestUnpackerGunzipStreamSmallInputFallsBack(t *testing.T) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	_, err := w.Write([]byte("tiny payload"))
+	assert.Ok(t, err)
+	assert.Ok(t, w.Close())
+
+	unpacker := NewUnpacker(&UnpackerOptions{ParallelGzip: true})
+	r, err := unpacker.GunzipStream(bytes.NewReader(buf.Bytes()))
+	assert.Ok(t, err)
+
+	out, err := ioutil.ReadAll(r)
+	assert.Ok(t, err)
+	assert.Equals(t, "tiny payload", string(out))
+}
@@ -0,0 +1,138 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package unpackit
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// hasWindowsDriveOrUNCPrefix reports whether name looks like a Windows drive
+// path ("C:\..." or "C:/...") or a UNC path ("\\server\share\..."). Checked
+// unconditionally, regardless of runtime.GOOS, since an archive crafted on
+// one platform can still be extracted on another.
+func hasWindowsDriveOrUNCPrefix(name string) bool {
+	if strings.HasPrefix(name, `\\`) || strings.HasPrefix(name, "//") {
+		return true
+	}
+	return len(name) >= 2 && name[1] == ':'
+}
+
+// secureJoin joins name onto destPath, rejecting anything that could place
+// the result outside of destPath: absolute paths, Windows drive/UNC paths,
+// and any ".."-climbing that filepath.Rel still finds after filepath.Clean
+// (e.g. "foo/../../etc/passwd").
+func secureJoin(destPath, name string) (string, error) {
+	return secureJoinRelativeTo(destPath, "", name)
+}
+
+// secureJoinRelativeTo joins name onto baseDir (itself relative to destPath),
+// rejecting it on the same terms as secureJoin: absolute paths, Windows
+// drive/UNC paths, and any result that filepath.Rel can't place back under
+// destPath. Used to validate a symlink's target, which resolves relative to
+// the directory containing the symlink rather than relative to destPath
+// itself.
+func secureJoinRelativeTo(destPath, baseDir, name string) (string, error) {
+	if filepath.IsAbs(name) || strings.HasPrefix(filepath.ToSlash(name), "/") {
+		return "", fmt.Errorf("unpackit: refusing to extract absolute path %q", name)
+	}
+
+	if hasWindowsDriveOrUNCPrefix(name) {
+		return "", fmt.Errorf("unpackit: refusing to extract Windows drive/UNC path %q", name)
+	}
+
+	cleaned := filepath.Clean(filepath.FromSlash(name))
+	full := filepath.Join(destPath, baseDir, cleaned)
+
+	rel, err := filepath.Rel(destPath, full)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("unpackit: %q escapes destination directory %q", name, destPath)
+	}
+
+	return full, nil
+}
+
+// extractionTracker resolves archive entry names to safe destination paths
+// and remembers which of those paths were extracted as symlinks, so a later
+// entry can't write through one of them (the "Zip Slip via symlink" attack:
+// a symlink entry pointing outside destPath, followed by a regular-file
+// entry whose name walks through that symlink).
+type extractionTracker struct {
+	destPath string
+	symlinks map[string]bool // paths relative to destPath that are symlinks
+}
+
+func newExtractionTracker(destPath string) *extractionTracker {
+	return &extractionTracker{destPath: destPath, symlinks: map[string]bool{}}
+}
+
+// resolve returns the safe destination path for name, or an error if name
+// escapes destPath or would write through a previously extracted symlink.
+func (t *extractionTracker) resolve(name string) (string, error) {
+	full, err := secureJoin(t.destPath, name)
+	if err != nil {
+		return "", err
+	}
+
+	rel, err := filepath.Rel(t.destPath, full)
+	if err != nil {
+		return "", err
+	}
+
+	if t.throughSymlink(rel) {
+		return "", fmt.Errorf("unpackit: %q writes through a previously extracted symlink", name)
+	}
+
+	return full, nil
+}
+
+// linkTarget validates a symlink's target (hdr.Linkname), resolving it the
+// way the filesystem actually will: relative to the directory containing the
+// symlink itself (filepath.Dir(name)), not relative to destPath. A target
+// like "../b/file.txt" in "dir/a/link.txt" is perfectly safe as long as it
+// still lands under destPath once resolved from "dir/a", even though joining
+// it onto destPath directly would look like it escapes. Returns the cleaned,
+// escape-checked text to hand to os.Symlink.
+func (t *extractionTracker) linkTarget(name, linkname string) (string, error) {
+	baseDir := filepath.Dir(name)
+
+	full, err := secureJoinRelativeTo(t.destPath, baseDir, linkname)
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Rel(filepath.Join(t.destPath, baseDir), full)
+}
+
+// markSymlink records that name was extracted as a symlink, so later entries
+// resolving through it are rejected.
+func (t *extractionTracker) markSymlink(name string) {
+	full, err := secureJoin(t.destPath, name)
+	if err != nil {
+		return
+	}
+
+	if rel, err := filepath.Rel(t.destPath, full); err == nil {
+		t.symlinks[rel] = true
+	}
+}
+
+func (t *extractionTracker) throughSymlink(rel string) bool {
+	dir := filepath.Dir(rel)
+	for dir != "." && dir != string(filepath.Separator) {
+		if t.symlinks[dir] {
+			return true
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			break
+		}
+		dir = parent
+	}
+
+	return false
+}
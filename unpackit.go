@@ -2,15 +2,13 @@
 // License, v. 2.0. If a copy of the MPL was not distributed with this
 // file, You can obtain one at http://mozilla.org/MPL/2.0/.
 
-// Package unzipit allows you to easily unpack *.tar.gz, *.tar.bzip2, *.tar.xz, *.zip and *.tar files.
+// Package unpackit allows you to easily unpack *.tar.gz, *.tar.bzip2, *.tar.xz, *.zip and *.tar files.
 // There are not CGO involved nor hard dependencies of any type.
-package unzipit
+package unpackit
 
 import (
-	"archive/tar"
 	"archive/zip"
 	"bufio"
-	"bytes"
 	"compress/bzip2"
 	"compress/gzip"
 	"errors"
@@ -20,54 +18,31 @@ import (
 	"os"
 	"path"
 	"path/filepath"
-	"runtime"
-	"strings"
 	"time"
 
 	"github.com/ulikunitz/xz"
 )
 
-var (
-	magicZIP  = []byte{0x50, 0x4b, 0x03, 0x04}
-	magicGZ   = []byte{0x1f, 0x8b}
-	magicBZIP = []byte{0x42, 0x5a}
-	magicTAR  = []byte{0x75, 0x73, 0x74, 0x61, 0x72} // at offset 257
-	magicXZ   = []byte{0xfd, 0x37, 0x7a, 0x58, 0x5a, 0x00}
-)
-
-// Check whether a file has the magic number for tar, gzip, bzip2 or zip files
+// Check whether a file has the magic number for tar, gzip, bzip2, zip, xz,
+// zstd or lz4 files. See DetectCompression for the byte patterns involved.
 //
 // Note that this function does not advance the Reader.
 //
-// 50 4b 03 04 for pkzip format
-// 1f 8b for .gz format
-// 42 5a for .bzip format
-// 75 73 74 61 72 at offset 257 for tar files
-// fd 37 7a 58 5a 00 for .xz format
+// offset is either 0, to detect a compression/archive format at the start of
+// the stream, or 257, to look for the ustar magic that identifies a tar
+// archive once the outer compression has been stripped.
 func magicNumber(reader *bufio.Reader, offset int) (string, error) {
-	headerBytes, err := reader.Peek(offset + 6)
+	headerBytes, err := reader.Peek(offset + 8)
 	if err != nil {
 		return "", err
 	}
 
-	magic := headerBytes[offset : offset+6]
-
-	if bytes.Equal(magicTAR, magic[0:5]) {
-		return "tar", nil
-	}
-
-	if bytes.Equal(magicZIP, magic[0:4]) {
-		return "zip", nil
+	if offset == 0 {
+		return DetectCompression(headerBytes).name(), nil
 	}
 
-	if bytes.Equal(magicGZ, magic[0:2]) {
-		return "gzip", nil
-	} else if bytes.Equal(magicBZIP, magic[0:2]) {
-		return "bzip", nil
-	}
-
-	if bytes.Equal(magicXZ, magic) {
-		return "xz", nil
+	if hasTarMagic(headerBytes) {
+		return "tar", nil
 	}
 
 	return "", nil
@@ -121,7 +96,7 @@ func UnpackStream(reader io.Reader, destPath string) (string, error) {
 	var decompressingReader *bufio.Reader
 	switch ftype {
 	case "gzip":
-		decompressingReader, err = GunzipStream(r)
+		decompressingReader, err = NewUnpacker(&UnpackerOptions{ParallelGzip: UseParallelGzip}).GunzipStream(r)
 		if err != nil {
 			return "", err
 		}
@@ -135,6 +110,16 @@ func UnpackStream(reader io.Reader, destPath string) (string, error) {
 		if err != nil {
 			return "", err
 		}
+	case "zstd":
+		decompressingReader, err = UnzstdStream(r)
+		if err != nil {
+			return "", err
+		}
+	case "lz4":
+		decompressingReader, err = Unlz4Stream(r)
+		if err != nil {
+			return "", err
+		}
 	case "zip":
 		// Like TAR, ZIP is also an archiving format, therefore we can just return
 		// after it finishes
@@ -153,7 +138,7 @@ func UnpackStream(reader io.Reader, destPath string) (string, error) {
 	}
 
 	// If it's not a TAR archive then save it to disk as is.
-	destRawFile := filepath.Join(destPath, sanitize(path.Base("unknown-pack")))
+	destRawFile := filepath.Join(destPath, path.Base("unknown-pack"))
 
 	// Creates destination file
 	destFile, err := os.Create(destRawFile)
@@ -249,26 +234,10 @@ func Unzip(file *os.File, destPath string) (string, error) {
 	return unpackZip(zr, destPath)
 }
 
-// UnzipStream unpacks a ZIP stream. Because of the nature of the ZIP format,
-// the stream is copied to memory before decompression.
-func UnzipStream(r io.Reader, destPath string) (string, error) {
-	data, err := ioutil.ReadAll(r)
-	if err != nil {
-		return "", err
-	}
-
-	memReader := bytes.NewReader(data)
-	zr, err := zip.NewReader(memReader, int64(len(data)))
-	if err != nil {
-		return "", err
-	}
-
-	return unpackZip(zr, destPath)
-}
-
 func unpackZip(zr *zip.Reader, destPath string) (string, error) {
+	tracker := newExtractionTracker(destPath)
 	for _, f := range zr.File {
-		err := unzipFile(f, destPath)
+		err := unzipFile(tracker, f, destPath)
 		if err != nil {
 			return "", err
 		}
@@ -276,12 +245,14 @@ func unpackZip(zr *zip.Reader, destPath string) (string, error) {
 	return destPath, nil
 }
 
-func unzipFile(f *zip.File, destPath string) error {
+func unzipFile(tracker *extractionTracker, f *zip.File, destPath string) error {
+	fp, err := tracker.resolve(f.Name)
+	if err != nil {
+		return err
+	}
+
 	if f.FileInfo().IsDir() {
-		if err := os.MkdirAll(filepath.Join(destPath, f.Name), f.Mode().Perm()); err != nil {
-			return err
-		}
-		return nil
+		return os.MkdirAll(fp, f.Mode().Perm())
 	}
 
 	rc, err := f.Open()
@@ -294,12 +265,9 @@ func unzipFile(f *zip.File, destPath string) error {
 		}
 	}()
 
-	filePath := sanitize(f.Name)
-	destPath = filepath.Join(destPath, filePath)
-
 	// If directories were not included in the archive but are part of the file name,
 	// we create them relative to the destination path.
-	fileDir := filepath.Dir(destPath)
+	fileDir := filepath.Dir(fp)
 	_, err = os.Lstat(fileDir)
 	if err != nil {
 		if err := os.MkdirAll(fileDir, 0700); err != nil {
@@ -307,7 +275,11 @@ func unzipFile(f *zip.File, destPath string) error {
 		}
 	}
 
-	file, err := os.Create(destPath)
+	if f.Mode()&os.ModeSymlink != 0 {
+		return unzipSymlink(tracker, f, rc, fp)
+	}
+
+	file, err := os.Create(fp)
 	if err != nil {
 		return err
 	}
@@ -333,99 +305,28 @@ func unzipFile(f *zip.File, destPath string) error {
 	return nil
 }
 
-// Untar unarchives a TAR archive and returns the final destination path or an error
-func Untar(data io.Reader, destPath string) (string, error) {
-	// Makes sure destPath exists
-	if err := os.MkdirAll(destPath, 0740); err != nil {
-		return "", err
-	}
-
-	tr := tar.NewReader(data)
-
-	// Iterate through the files in the archive.
-	rootdir := destPath
-	for {
-		hdr, err := tr.Next()
-		if err == io.EOF {
-			// end of tar archive
-			break
-		}
-
-		if err != nil {
-			return rootdir, err
-		}
-
-		// Skip pax_global_header with the commit ID this archive was created from
-		if hdr.Name == "pax_global_header" {
-			continue
-		}
-
-		fp := filepath.Join(destPath, sanitize(hdr.Name))
-		if hdr.FileInfo().IsDir() {
-			if rootdir == destPath {
-				rootdir = fp
-			}
-
-			if err := os.MkdirAll(fp, os.FileMode(hdr.Mode)); err != nil {
-				return rootdir, err
-			}
-			continue
-		}
-
-		_, untarErr := untarFile(hdr, tr, fp, rootdir)
-		if untarErr != nil {
-			return rootdir, untarErr
-		}
-	}
-
-	return rootdir, nil
-}
-
-func untarFile(hdr *tar.Header, tr *tar.Reader, fp, rootdir string) (string, error) {
-	parentDir, _ := filepath.Split(fp)
-
-	if err := os.MkdirAll(parentDir, 0740); err != nil {
-		return rootdir, err
-	}
-
-	file, err := os.Create(fp)
+// unzipSymlink recreates a symlink entry. ZIP has no dedicated field for the
+// link target, unlike TAR's Linkname: it's stored as the entry's "file"
+// content instead, so it has to be read out like any other entry's data.
+func unzipSymlink(tracker *extractionTracker, f *zip.File, rc io.Reader, fp string) error {
+	target, err := ioutil.ReadAll(rc)
 	if err != nil {
-		return rootdir, err
-	}
-
-	defer func() {
-		if err := file.Close(); err != nil {
-			log.Println(err)
-		}
-	}()
-
-	if err := file.Chmod(os.FileMode(hdr.Mode)); err != nil {
-		log.Printf("warn: failed setting file permissions for %q: %#v", file.Name(), err)
-	}
-
-	if err := os.Chtimes(file.Name(), time.Now(), hdr.ModTime); err != nil {
-		log.Printf("warn: failed setting file atime and mtime for %q: %#v", file.Name(), err)
+		return err
 	}
 
-	if _, err := io.Copy(file, tr); err != nil {
-		return rootdir, err
+	linkname, err := tracker.linkTarget(f.Name, string(target))
+	if err != nil {
+		return err
 	}
 
-	return rootdir, nil
-}
-
-// Sanitizes name to avoid overwriting sensitive system files when unarchiving
-func sanitize(name string) string {
-	// Gets rid of volume drive label in Windows
-	if len(name) > 1 && name[1] == ':' && runtime.GOOS == "windows" {
-		name = name[2:]
+	if err := os.RemoveAll(fp); err != nil {
+		return err
 	}
 
-	name = filepath.Clean(name)
-	name = filepath.ToSlash(name)
-	for strings.HasPrefix(name, "../") {
-		name = name[3:]
+	if err := os.Symlink(linkname, fp); err != nil {
+		return err
 	}
 
-	return name
+	tracker.markSymlink(f.Name)
+	return nil
 }
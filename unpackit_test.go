@@ -13,6 +13,7 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"syscall"
 	"testing"
 
 	"github.com/bradfitz/iter"
@@ -121,6 +122,238 @@ func TestUntar(t *testing.T) {
 	assert.Ok(t, err)
 }
 
+func TestUntarSymlink(t *testing.T) {
+	buf := new(bytes.Buffer)
+	tw := tar.NewWriter(buf)
+
+	err := tw.WriteHeader(&tar.Header{
+		Name:     "real.txt",
+		Size:     int64(len("hello")),
+		Typeflag: tar.TypeReg,
+	})
+	assert.Ok(t, err)
+	_, err = tw.Write([]byte("hello"))
+	assert.Ok(t, err)
+
+	err = tw.WriteHeader(&tar.Header{
+		Name:     "link.txt",
+		Linkname: "real.txt",
+		Typeflag: tar.TypeSymlink,
+	})
+	assert.Ok(t, err)
+
+	assert.Ok(t, tw.Close())
+
+	destDir, err := ioutil.TempDir(os.TempDir(), "unpackit-symlink")
+	assert.Ok(t, err)
+	defer os.RemoveAll(destDir)
+
+	_, err = Untar(bytes.NewReader(buf.Bytes()), destDir)
+	assert.Ok(t, err)
+
+	target, err := os.Readlink(filepath.Join(destDir, "link.txt"))
+	assert.Ok(t, err)
+	assert.Equals(t, "real.txt", target)
+}
+
+// TestUntarChownOptsAppliesToSymlinksAndHardlinks covers TarOptions.ChownOpts,
+// which must override the owner/group of every extracted entry type, not
+// just regular files.
+func TestUntarChownOptsAppliesToSymlinksAndHardlinks(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("chowning to an arbitrary owner requires root")
+	}
+
+	buf := new(bytes.Buffer)
+	tw := tar.NewWriter(buf)
+
+	body := []byte("hello")
+	assert.Ok(t, tw.WriteHeader(&tar.Header{
+		Name:     "real.txt",
+		Size:     int64(len(body)),
+		Typeflag: tar.TypeReg,
+		Uid:      0,
+		Gid:      0,
+	}))
+	_, err := tw.Write(body)
+	assert.Ok(t, err)
+
+	assert.Ok(t, tw.WriteHeader(&tar.Header{
+		Name:     "link.txt",
+		Linkname: "real.txt",
+		Typeflag: tar.TypeSymlink,
+		Uid:      0,
+		Gid:      0,
+	}))
+
+	assert.Ok(t, tw.WriteHeader(&tar.Header{
+		Name:     "hardlink.txt",
+		Linkname: "real.txt",
+		Typeflag: tar.TypeLink,
+		Uid:      0,
+		Gid:      0,
+	}))
+
+	assert.Ok(t, tw.Close())
+
+	destDir, err := ioutil.TempDir(os.TempDir(), "unpackit-chown")
+	assert.Ok(t, err)
+	defer os.RemoveAll(destDir)
+
+	archiver := NewArchiver(&TarOptions{ChownOpts: &ChownOpts{UID: 1234, GID: 1234}})
+	_, err = archiver.Untar(bytes.NewReader(buf.Bytes()), destDir)
+	assert.Ok(t, err)
+
+	for _, name := range []string{"real.txt", "link.txt", "hardlink.txt"} {
+		fi, err := os.Lstat(filepath.Join(destDir, name))
+		assert.Ok(t, err)
+
+		stat, ok := fi.Sys().(*syscall.Stat_t)
+		assert.Cond(t, ok, "expected a *syscall.Stat_t for %q", name)
+		assert.Equals(t, uint32(1234), stat.Uid)
+		assert.Equals(t, uint32(1234), stat.Gid)
+	}
+}
+
+// TestUntarIncludeExcludePatterns covers TarOptions.IncludePatterns and
+// ExcludePatterns, including that exclude takes precedence over include.
+func TestUntarIncludeExcludePatterns(t *testing.T) {
+	buf := new(bytes.Buffer)
+	tw := tar.NewWriter(buf)
+
+	var files = []string{"keep.txt", "also-keep.txt", "drop.txt", "other.log"}
+	for _, name := range files {
+		assert.Ok(t, tw.WriteHeader(&tar.Header{
+			Name:     name,
+			Size:     int64(len(name)),
+			Typeflag: tar.TypeReg,
+		}))
+		_, err := tw.Write([]byte(name))
+		assert.Ok(t, err)
+	}
+	assert.Ok(t, tw.Close())
+
+	destDir, err := ioutil.TempDir(os.TempDir(), "unpackit-patterns")
+	assert.Ok(t, err)
+	defer os.RemoveAll(destDir)
+
+	archiver := NewArchiver(&TarOptions{
+		IncludePatterns: []string{"*.txt"},
+		ExcludePatterns: []string{"drop.txt"},
+	})
+	_, err = archiver.Untar(bytes.NewReader(buf.Bytes()), destDir)
+	assert.Ok(t, err)
+
+	for _, name := range []string{"keep.txt", "also-keep.txt"} {
+		_, err := os.Stat(filepath.Join(destDir, name))
+		assert.Ok(t, err)
+	}
+
+	for _, name := range []string{"drop.txt", "other.log"} {
+		_, err := os.Stat(filepath.Join(destDir, name))
+		assert.Cond(t, os.IsNotExist(err), "expected %q to have been skipped", name)
+	}
+}
+
+// TestUntarNoLchownSkipsChowning covers TarOptions.NoLchown: with it set,
+// extracted files keep whatever owner the extracting process already has,
+// rather than being chowned at all.
+func TestUntarNoLchownSkipsChowning(t *testing.T) {
+	buf := new(bytes.Buffer)
+	tw := tar.NewWriter(buf)
+
+	body := []byte("hello")
+	assert.Ok(t, tw.WriteHeader(&tar.Header{
+		Name:     "real.txt",
+		Size:     int64(len(body)),
+		Typeflag: tar.TypeReg,
+		Uid:      1234,
+		Gid:      1234,
+	}))
+	_, err := tw.Write(body)
+	assert.Ok(t, err)
+	assert.Ok(t, tw.Close())
+
+	destDir, err := ioutil.TempDir(os.TempDir(), "unpackit-nolchown")
+	assert.Ok(t, err)
+	defer os.RemoveAll(destDir)
+
+	archiver := NewArchiver(&TarOptions{NoLchown: true})
+	_, err = archiver.Untar(bytes.NewReader(buf.Bytes()), destDir)
+	assert.Ok(t, err)
+
+	fi, err := os.Lstat(filepath.Join(destDir, "real.txt"))
+	assert.Ok(t, err)
+
+	stat, ok := fi.Sys().(*syscall.Stat_t)
+	assert.Cond(t, ok, "expected a *syscall.Stat_t for real.txt")
+	assert.Equals(t, uint32(os.Getuid()), stat.Uid)
+	assert.Equals(t, uint32(os.Getgid()), stat.Gid)
+}
+
+// TestUntarPreserveOwnersAppliesHeaderOwner covers the PreserveOwners vs.
+// default-to-calling-user behavior described by TarOptions: without
+// PreserveOwners, entries are chowned to the calling user even when the tar
+// header carries a different owner; with it set, the header's owner wins.
+func TestUntarPreserveOwnersAppliesHeaderOwner(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("chowning to an arbitrary owner requires root")
+	}
+
+	newHeaderOwned := func() *bytes.Buffer {
+		buf := new(bytes.Buffer)
+		tw := tar.NewWriter(buf)
+
+		body := []byte("hello")
+		assert.Ok(t, tw.WriteHeader(&tar.Header{
+			Name:     "real.txt",
+			Size:     int64(len(body)),
+			Typeflag: tar.TypeReg,
+			Uid:      1234,
+			Gid:      1234,
+		}))
+		_, err := tw.Write(body)
+		assert.Ok(t, err)
+		assert.Ok(t, tw.Close())
+
+		return buf
+	}
+
+	t.Run("default falls back to the calling user", func(t *testing.T) {
+		destDir, err := ioutil.TempDir(os.TempDir(), "unpackit-default-owner")
+		assert.Ok(t, err)
+		defer os.RemoveAll(destDir)
+
+		archiver := NewArchiver(&TarOptions{})
+		_, err = archiver.Untar(newHeaderOwned(), destDir)
+		assert.Ok(t, err)
+
+		fi, err := os.Lstat(filepath.Join(destDir, "real.txt"))
+		assert.Ok(t, err)
+		stat, ok := fi.Sys().(*syscall.Stat_t)
+		assert.Cond(t, ok, "expected a *syscall.Stat_t for real.txt")
+		assert.Equals(t, uint32(os.Getuid()), stat.Uid)
+		assert.Equals(t, uint32(os.Getgid()), stat.Gid)
+	})
+
+	t.Run("PreserveOwners applies the header owner", func(t *testing.T) {
+		destDir, err := ioutil.TempDir(os.TempDir(), "unpackit-preserve-owner")
+		assert.Ok(t, err)
+		defer os.RemoveAll(destDir)
+
+		archiver := NewArchiver(&TarOptions{PreserveOwners: true})
+		_, err = archiver.Untar(newHeaderOwned(), destDir)
+		assert.Ok(t, err)
+
+		fi, err := os.Lstat(filepath.Join(destDir, "real.txt"))
+		assert.Ok(t, err)
+		stat, ok := fi.Sys().(*syscall.Stat_t)
+		assert.Cond(t, ok, "expected a *syscall.Stat_t for real.txt")
+		assert.Equals(t, uint32(1234), stat.Uid)
+		assert.Equals(t, uint32(1234), stat.Gid)
+	})
+}
+
 func TestUntarOpenFileResourceLeak(t *testing.T) {
 	// Create a buffer to write our archive to.
 	buf := new(bytes.Buffer)
@@ -212,28 +445,127 @@ func TestUnzipOpenFileResourceLeak(t *testing.T) {
 	assert.Ok(t, err)
 }
 
-func TestSanitize(t *testing.T) {
+func TestSecureJoin(t *testing.T) {
+	destPath := string(filepath.Separator) + filepath.Join("tmp", "unpackit-destination")
+
 	var tests = []struct {
-		malicious string
-		sanitized string
+		name     string
+		resolved string // relative to destPath; empty means an error is expected
 	}{
-		{"../../.././etc/passwd", "etc/passwd"},
-		{"../../etc/passwd", "etc/passwd"},
 		{"./etc/passwd", "etc/passwd"},
 		{"./././etc/passwd", "etc/passwd"},
 		{"nonexistant/b/../file.txt", "nonexistant/file.txt"},
 		{"abc../def", "abc../def"},
 		{"a/b/c/../d", "a/b/d"},
-		{"a/../../c", "c"},
 		{"...../etc/password", "...../etc/password"},
+		{"../../.././etc/passwd", ""},
+		{"../../etc/passwd", ""},
+		{"a/../../c", ""},
+		{"foo/../../etc/passwd", ""},
+		{"/etc/passwd", ""},
+		{`\\server\share\x`, ""},
+		{`C:\Windows\System32`, ""},
 	}
 
 	for _, test := range tests {
-		a := sanitize(test.malicious)
-		assert.Equals(t, test.sanitized, a)
+		full, err := secureJoin(destPath, test.name)
+		if test.resolved == "" {
+			assert.Cond(t, err != nil, "expected %q to be rejected, got %q", test.name, full)
+			continue
+		}
+
+		assert.Ok(t, err)
+		assert.Equals(t, filepath.Join(destPath, test.resolved), full)
 	}
 }
 
+func TestExtractionTrackerRejectsSymlinkTraversal(t *testing.T) {
+	destPath := string(filepath.Separator) + filepath.Join("tmp", "unpackit-destination")
+	tracker := newExtractionTracker(destPath)
+
+	// "mylink" resolves and extracts fine as a symlink...
+	_, err := tracker.resolve("mylink")
+	assert.Ok(t, err)
+	tracker.markSymlink("mylink")
+
+	// ...but a later entry can't be written through it, even though the
+	// joined path itself doesn't escape destPath.
+	_, err = tracker.resolve("mylink/passwd")
+	assert.Cond(t, err != nil, "expected writing through a tracked symlink to be rejected")
+
+	_, err = tracker.resolve("mylink/nested/passwd")
+	assert.Cond(t, err != nil, "expected writing through a tracked symlink to be rejected at any depth")
+}
+
+// TestUntarSymlinkTraversal covers the "Zip Slip via symlink" attack end to
+// end: a symlink entry pointing outside destPath, followed by a regular-file
+// entry that walks through it. Both the symlink itself and the file that
+// tries to use it must be rejected.
+func TestUntarSymlinkTraversal(t *testing.T) {
+	buf := new(bytes.Buffer)
+	tw := tar.NewWriter(buf)
+
+	err := tw.WriteHeader(&tar.Header{
+		Name:     "mylink",
+		Linkname: "/etc",
+		Typeflag: tar.TypeSymlink,
+	})
+	assert.Ok(t, err)
+	assert.Ok(t, tw.Close())
+
+	destDir, err := ioutil.TempDir(os.TempDir(), "unpackit-symlink-traversal")
+	assert.Ok(t, err)
+	defer os.RemoveAll(destDir)
+
+	_, err = Untar(bytes.NewReader(buf.Bytes()), destDir)
+	assert.Cond(t, err != nil, "expected symlink pointing outside destPath to be rejected")
+
+	_, err = os.Lstat(filepath.Join(destDir, "mylink"))
+	assert.Cond(t, os.IsNotExist(err), "expected mylink to not have been created")
+}
+
+// TestUntarSymlinkRelativeToOwnDirectory covers a realistic relative symlink
+// that climbs out of its own subdirectory while still staying inside
+// destPath (e.g. typical "lib/foo.so -> ../other/foo.so.1" layouts). Its
+// target must be resolved relative to the symlink's own directory, not
+// relative to destPath, or this gets rejected as an escape even though it's
+// safe.
+func TestUntarSymlinkRelativeToOwnDirectory(t *testing.T) {
+	buf := new(bytes.Buffer)
+	tw := tar.NewWriter(buf)
+
+	body := []byte("hello")
+	assert.Ok(t, tw.WriteHeader(&tar.Header{
+		Name:     "dir/b/file.txt",
+		Size:     int64(len(body)),
+		Typeflag: tar.TypeReg,
+	}))
+	_, err := tw.Write(body)
+	assert.Ok(t, err)
+
+	assert.Ok(t, tw.WriteHeader(&tar.Header{
+		Name:     "dir/a/link.txt",
+		Linkname: "../b/file.txt",
+		Typeflag: tar.TypeSymlink,
+	}))
+	assert.Ok(t, tw.Close())
+
+	destDir, err := ioutil.TempDir(os.TempDir(), "unpackit-symlink-relative")
+	assert.Ok(t, err)
+	defer os.RemoveAll(destDir)
+
+	_, err = Untar(bytes.NewReader(buf.Bytes()), destDir)
+	assert.Ok(t, err)
+
+	target, err := os.Readlink(filepath.Join(destDir, "dir/a/link.txt"))
+	assert.Ok(t, err)
+	assert.Equals(t, "../b/file.txt", target)
+
+	got, err := ioutil.ReadFile(filepath.Join(destDir, "dir/a/link.txt"))
+	assert.Ok(t, err)
+	assert.Equals(t, string(body), string(got))
+}
+
 func calcNumberOfFiles(t *testing.T, searchDir string) int {
 	fileList := []string{}
 
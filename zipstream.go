@@ -0,0 +1,316 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package unpackit
+
+import (
+	"archive/zip"
+	"compress/flate"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	localFileHeaderSignature  = 0x04034b50
+	dataDescriptorSignature   = 0x08074b50
+	centralDirectorySignature = 0x02014b50
+	zip64ExtraFieldID         = 0x0001
+	flagDataDescriptor        = 0x8
+)
+
+var (
+	decompressorsMu sync.RWMutex
+	decompressors   = map[uint16]zip.Decompressor{}
+)
+
+// RegisterDecompressor registers a decompressor for the given ZIP method ID,
+// for use by UnzipStream's method-0/method-8-agnostic streaming path. It
+// mirrors archive/zip.RegisterDecompressor, but keeps its own registry so
+// registering here doesn't also change the behavior of Unzip's archive/zip.Reader.
+func RegisterDecompressor(method uint16, dcomp zip.Decompressor) {
+	decompressorsMu.Lock()
+	defer decompressorsMu.Unlock()
+	decompressors[method] = dcomp
+}
+
+func decompressor(method uint16) (zip.Decompressor, error) {
+	switch method {
+	case zip.Store:
+		return func(r io.Reader) io.ReadCloser { return io.NopCloser(r) }, nil
+	case zip.Deflate:
+		return func(r io.Reader) io.ReadCloser { return flate.NewReader(r) }, nil
+	}
+
+	decompressorsMu.RLock()
+	defer decompressorsMu.RUnlock()
+	if dcomp, ok := decompressors[method]; ok {
+		return dcomp, nil
+	}
+
+	return nil, fmt.Errorf("unpackit: unsupported zip compression method %d", method)
+}
+
+// zipLocalHeader is the parsed form of a ZIP local file header (PK\x03\x04),
+// with its Zip64 extra field, if any, already folded into compressedSize and
+// uncompressedSize.
+type zipLocalHeader struct {
+	name              string
+	flags             uint16
+	method            uint16
+	modified          time.Time
+	compressedSize    int64
+	uncompressedSize  int64
+	hasDataDescriptor bool
+	isZip64           bool
+}
+
+// UnzipStream unpacks a ZIP stream without buffering it in memory first. It
+// walks local file headers sequentially (magic 0x04034b50) and decompresses
+// each entry as its data is read, honoring Zip64 extra fields (0x0001) so
+// UncompressedSize/CompressedSize values above 4GiB are handled correctly.
+//
+// It stops as soon as it hits anything other than a local file header —
+// typically the central directory (0x02014b50) — since the central directory
+// only repeats information already available from the local headers we just
+// streamed through.
+func UnzipStream(r io.Reader, destPath string) (string, error) {
+	tracker := newExtractionTracker(destPath)
+
+	for {
+		sig, err := readUint32(r)
+		if err == io.EOF {
+			// The stream ended right after the last entry, with no central
+			// directory to signal that explicitly.
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+
+		if sig != localFileHeaderSignature {
+			break
+		}
+
+		hdr, err := readZipLocalHeader(r)
+		if err != nil {
+			return "", err
+		}
+
+		if err := unzipStreamEntry(tracker, r, hdr); err != nil {
+			return "", err
+		}
+	}
+
+	return destPath, nil
+}
+
+func readZipLocalHeader(r io.Reader) (*zipLocalHeader, error) {
+	fixed := make([]byte, 26)
+	if _, err := io.ReadFull(r, fixed); err != nil {
+		return nil, err
+	}
+
+	flags := binary.LittleEndian.Uint16(fixed[2:4])
+	method := binary.LittleEndian.Uint16(fixed[4:6])
+	modTime := binary.LittleEndian.Uint16(fixed[6:8])
+	modDate := binary.LittleEndian.Uint16(fixed[8:10])
+	compressedSize := uint64(binary.LittleEndian.Uint32(fixed[14:18]))
+	uncompressedSize := uint64(binary.LittleEndian.Uint32(fixed[18:22]))
+	nameLen := binary.LittleEndian.Uint16(fixed[22:24])
+	extraLen := binary.LittleEndian.Uint16(fixed[24:26])
+
+	name := make([]byte, nameLen)
+	if _, err := io.ReadFull(r, name); err != nil {
+		return nil, err
+	}
+
+	extra := make([]byte, extraLen)
+	if _, err := io.ReadFull(r, extra); err != nil {
+		return nil, err
+	}
+
+	isZip64, zip64Compressed, zip64Uncompressed := parseZip64Extra(extra, compressedSize, uncompressedSize)
+	if isZip64 {
+		compressedSize, uncompressedSize = zip64Compressed, zip64Uncompressed
+	}
+
+	return &zipLocalHeader{
+		name:              string(name),
+		flags:             flags,
+		method:            method,
+		modified:          msDosTimeToTime(modDate, modTime),
+		compressedSize:    int64(compressedSize),
+		uncompressedSize:  int64(uncompressedSize),
+		hasDataDescriptor: flags&flagDataDescriptor != 0,
+		isZip64:           isZip64,
+	}, nil
+}
+
+// parseZip64Extra looks for the 0x0001 extra field and returns the Zip64
+// sizes it carries, falling back to the 32-bit sizes already read from the
+// fixed part of the local header when no Zip64 extra is present.
+func parseZip64Extra(extra []byte, compressedSize, uncompressedSize uint64) (found bool, compressed, uncompressed uint64) {
+	compressed, uncompressed = compressedSize, uncompressedSize
+
+	for len(extra) >= 4 {
+		id := binary.LittleEndian.Uint16(extra[0:2])
+		size := binary.LittleEndian.Uint16(extra[2:4])
+		if len(extra) < int(4+size) {
+			break
+		}
+		data := extra[4 : 4+size]
+
+		if id == zip64ExtraFieldID {
+			found = true
+			// Order within the Zip64 extra field is: uncompressed size,
+			// compressed size, each only present in the local header
+			// variant (no relative-offset/disk fields here).
+			if len(data) >= 8 {
+				uncompressed = binary.LittleEndian.Uint64(data[0:8])
+			}
+			if len(data) >= 16 {
+				compressed = binary.LittleEndian.Uint64(data[8:16])
+			}
+		}
+
+		extra = extra[4+size:]
+	}
+
+	return found, compressed, uncompressed
+}
+
+// unzipStreamEntry writes a single streamed entry to destPath, guarded by
+// tracker the same way the buffered zip path is. Unlike unzipFile, it cannot
+// detect symlink entries: the symlink mode bit lives in the ZIP central
+// directory's external file attributes, which a sequential local-header walk
+// never sees.
+func unzipStreamEntry(tracker *extractionTracker, r io.Reader, hdr *zipLocalHeader) error {
+	filePath, err := tracker.resolve(hdr.name)
+	if err != nil {
+		return err
+	}
+
+	if isZipDirEntry(hdr.name) {
+		return os.MkdirAll(filePath, 0750)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(filePath), 0750); err != nil {
+		return err
+	}
+
+	dcomp, err := decompressor(hdr.method)
+	if err != nil {
+		return err
+	}
+
+	if hdr.method == zip.Store && hdr.hasDataDescriptor {
+		// Store's decompressor is a bare passthrough with no framing of its
+		// own, unlike Deflate, which self-terminates at its end-of-stream
+		// marker. Without a declared size up front, there's no way to know
+		// where this entry's data ends short of scanning for the data
+		// descriptor signature, which UnzipStream doesn't do: reading on
+		// would run straight through the rest of the archive.
+		return fmt.Errorf("unpackit: zip entry %q uses Store compression with a streamed data descriptor; its size isn't known upfront and can't be determined while streaming, use Unzip on a seekable file instead", hdr.name)
+	}
+
+	var compressed io.Reader = r
+	if !hdr.hasDataDescriptor {
+		// Sizes are known upfront: never read past this entry's declared
+		// boundary, even if the compressed data turns out to be corrupt.
+		compressed = io.LimitReader(r, hdr.compressedSize)
+	}
+
+	rc := dcomp(compressed)
+	defer func() {
+		if err := rc.Close(); err != nil {
+			log.Println(err)
+		}
+	}()
+
+	file, err := os.Create(filePath)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := file.Close(); err != nil {
+			log.Println(err)
+		}
+	}()
+
+	if _, err := io.Copy(file, rc); err != nil {
+		return err
+	}
+
+	if err := os.Chtimes(filePath, time.Now(), hdr.modified); err != nil {
+		log.Printf("warn: failed setting file atime and mtime for %q: %#v", filePath, err)
+	}
+
+	if hdr.hasDataDescriptor {
+		return skipDataDescriptor(r, hdr.isZip64)
+	}
+
+	return nil
+}
+
+// skipDataDescriptor consumes the optional data descriptor that follows an
+// entry's compressed data when General Purpose bit 3 is set. The signature
+// is optional per APPNOTE.TXT, so we peek for it via a 4-byte read and only
+// treat it as the CRC field if it doesn't match.
+func skipDataDescriptor(r io.Reader, isZip64 bool) error {
+	first, err := readUint32(r)
+	if err != nil {
+		return err
+	}
+
+	sizeWidth := int64(4)
+	if isZip64 {
+		sizeWidth = 8
+	}
+
+	remaining := sizeWidth * 2
+	if first == dataDescriptorSignature {
+		// first was the signature, so the crc32 field is still ahead.
+		remaining += 4
+	}
+	// Otherwise first was already the crc32 field; only the size fields remain.
+
+	_, err = io.CopyN(io.Discard, r, remaining)
+	return err
+}
+
+// isZipDirEntry reports whether a ZIP entry name denotes a directory, the
+// same convention archive/zip itself uses.
+func isZipDirEntry(name string) bool {
+	return strings.HasSuffix(name, "/")
+}
+
+func readUint32(r io.Reader) (uint32, error) {
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint32(buf), nil
+}
+
+// msDosTimeToTime converts MS-DOS date/time fields, as stored in a ZIP local
+// file header, into a time.Time in the same way archive/zip does internally.
+func msDosTimeToTime(d, t uint16) time.Time {
+	return time.Date(
+		int(d>>9)+1980,
+		time.Month(d>>5&0xf),
+		int(d&0x1f),
+		int(t>>11),
+		int(t>>5&0x3f),
+		int(t&0x1f)*2,
+		0,
+		time.UTC,
+	)
+}
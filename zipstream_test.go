@@ -0,0 +1,209 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package unpackit
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hooklift/assert"
+)
+
+// TestUnzipStream covers the path archive/zip.Writer actually produces:
+// every entry streamed with General Purpose bit 3 set and a trailing data
+// descriptor, which only self-terminating methods like Deflate support
+// without knowing the compressed size upfront.
+func TestUnzipStream(t *testing.T) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+
+	var files = []struct {
+		Name, Body string
+	}{
+		{"readme.txt", "This archive contains some text files."},
+		{"dir/nested.txt", "Nested under a directory entry."},
+	}
+
+	for _, file := range files {
+		w, err := zw.CreateHeader(&zip.FileHeader{
+			Name:   file.Name,
+			Method: zip.Deflate,
+		})
+		assert.Ok(t, err)
+		_, err = w.Write([]byte(file.Body))
+		assert.Ok(t, err)
+	}
+
+	assert.Ok(t, zw.Close())
+
+	destDir, err := ioutil.TempDir(os.TempDir(), "unpackit-zipstream")
+	assert.Ok(t, err)
+	defer os.RemoveAll(destDir)
+
+	_, err = UnzipStream(bytes.NewReader(buf.Bytes()), destDir)
+	assert.Ok(t, err)
+
+	for _, file := range files {
+		got, err := ioutil.ReadFile(filepath.Join(destDir, file.Name))
+		assert.Ok(t, err)
+		assert.Equals(t, file.Body, string(got))
+	}
+}
+
+// TestUnzipStreamKnownSizes covers the more common case found in zips
+// produced by CLI tools, where sizes are known upfront and General Purpose
+// bit 3 is clear, so a Store entry's boundary is just its declared size.
+func TestUnzipStreamKnownSizes(t *testing.T) {
+	body := []byte("Stored without compression.")
+
+	var compressed bytes.Buffer
+	fw, err := flate.NewWriter(&compressed, flate.DefaultCompression)
+	assert.Ok(t, err)
+	_, err = fw.Write([]byte("Deflated but with a known size."))
+	assert.Ok(t, err)
+	assert.Ok(t, fw.Close())
+
+	var buf bytes.Buffer
+	writeLocalFileHeader(&buf, "stored.txt", zip.Store, body)
+	buf.Write(body)
+	writeLocalFileHeader(&buf, "deflated.txt", zip.Deflate, compressed.Bytes())
+	buf.Write(compressed.Bytes())
+
+	destDir, err := ioutil.TempDir(os.TempDir(), "unpackit-zipstream-known")
+	assert.Ok(t, err)
+	defer os.RemoveAll(destDir)
+
+	_, err = UnzipStream(bytes.NewReader(buf.Bytes()), destDir)
+	assert.Ok(t, err)
+
+	got, err := ioutil.ReadFile(filepath.Join(destDir, "stored.txt"))
+	assert.Ok(t, err)
+	assert.Equals(t, string(body), string(got))
+
+	got, err = ioutil.ReadFile(filepath.Join(destDir, "deflated.txt"))
+	assert.Ok(t, err)
+	assert.Equals(t, "Deflated but with a known size.", string(got))
+}
+
+// TestUnzipStreamZip64Extra covers a local header whose 32-bit size fields
+// are the 0xFFFFFFFF sentinel that signals the real sizes live in the 0x0001
+// Zip64 extra field instead, as required for entries above 4GiB.
+func TestUnzipStreamZip64Extra(t *testing.T) {
+	body := []byte("Zip64 sentinel sizes, tiny body.")
+
+	var buf bytes.Buffer
+	writeLocalFileHeaderZip64(&buf, "big.bin", zip.Store, body)
+	buf.Write(body)
+
+	destDir, err := ioutil.TempDir(os.TempDir(), "unpackit-zipstream-zip64")
+	assert.Ok(t, err)
+	defer os.RemoveAll(destDir)
+
+	_, err = UnzipStream(bytes.NewReader(buf.Bytes()), destDir)
+	assert.Ok(t, err)
+
+	got, err := ioutil.ReadFile(filepath.Join(destDir, "big.bin"))
+	assert.Ok(t, err)
+	assert.Equals(t, string(body), string(got))
+}
+
+// writeLocalFileHeaderZip64 appends a local file header whose size fields are
+// the 0xFFFFFFFF sentinel, carrying the real sizes in a 0x0001 Zip64 extra
+// field instead, the way a real Zip64 archive does for entries above 4GiB.
+func writeLocalFileHeaderZip64(buf *bytes.Buffer, name string, method uint16, data []byte) {
+	extra := make([]byte, 20)
+	binary.LittleEndian.PutUint16(extra[0:2], zip64ExtraFieldID)
+	binary.LittleEndian.PutUint16(extra[2:4], 16)
+	binary.LittleEndian.PutUint64(extra[4:12], uint64(len(data)))  // uncompressed size
+	binary.LittleEndian.PutUint64(extra[12:20], uint64(len(data))) // compressed size
+
+	var h [26]byte
+	binary.LittleEndian.PutUint16(h[2:4], 0) // no flags: sizes below are authoritative
+	binary.LittleEndian.PutUint16(h[4:6], method)
+	binary.LittleEndian.PutUint32(h[14:18], 0xFFFFFFFF) // compressed size sentinel
+	binary.LittleEndian.PutUint32(h[18:22], 0xFFFFFFFF) // uncompressed size sentinel
+	binary.LittleEndian.PutUint16(h[22:24], uint16(len(name)))
+	binary.LittleEndian.PutUint16(h[24:26], uint16(len(extra)))
+
+	var sig [4]byte
+	binary.LittleEndian.PutUint32(sig[:], localFileHeaderSignature)
+
+	buf.Write(sig[:])
+	buf.Write(h[:])
+	buf.WriteString(name)
+	buf.Write(extra)
+}
+
+// TestUnzipStreamStoreWithDataDescriptorErrors covers a Store entry written
+// through zip.Writer, which sets the data-descriptor flag regardless of
+// method. Store has no framing of its own to find the end of its data, so
+// this must fail loudly instead of reading through the rest of the stream.
+func TestUnzipStreamStoreWithDataDescriptorErrors(t *testing.T) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+
+	var files = []struct {
+		Name, Body string
+	}{
+		{"first.bin", "AAAA"},
+		{"second.bin", "BBBB"},
+	}
+
+	for _, file := range files {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: file.Name, Method: zip.Store})
+		assert.Ok(t, err)
+		_, err = w.Write([]byte(file.Body))
+		assert.Ok(t, err)
+	}
+
+	assert.Ok(t, zw.Close())
+
+	destDir, err := ioutil.TempDir(os.TempDir(), "unpackit-zipstream-store-dd")
+	assert.Ok(t, err)
+	defer os.RemoveAll(destDir)
+
+	_, err = UnzipStream(bytes.NewReader(buf.Bytes()), destDir)
+	assert.Cond(t, err != nil, "expected an error instead of silently reading through the stream")
+
+	_, err = os.Stat(filepath.Join(destDir, "first.bin"))
+	assert.Cond(t, os.IsNotExist(err), "expected first.bin to not have been written with corrupted contents")
+}
+
+// writeLocalFileHeader appends a minimal local file header, without a data
+// descriptor, for data of the given (already compressed) length.
+func writeLocalFileHeader(buf *bytes.Buffer, name string, method uint16, data []byte) {
+	var h [26]byte
+	binary.LittleEndian.PutUint16(h[2:4], 0) // no flags: sizes below are authoritative
+	binary.LittleEndian.PutUint16(h[4:6], method)
+	binary.LittleEndian.PutUint32(h[14:18], uint32(len(data)))
+	binary.LittleEndian.PutUint32(h[18:22], uint32(len(data)))
+	binary.LittleEndian.PutUint16(h[22:24], uint16(len(name)))
+
+	var sig [4]byte
+	binary.LittleEndian.PutUint32(sig[:], localFileHeaderSignature)
+
+	buf.Write(sig[:])
+	buf.Write(h[:])
+	buf.WriteString(name)
+}
+
+func TestRegisterDecompressor(t *testing.T) {
+	const customMethod = 99
+
+	RegisterDecompressor(customMethod, func(r io.Reader) io.ReadCloser {
+		return ioutil.NopCloser(r)
+	})
+
+	dcomp, err := decompressor(customMethod)
+	assert.Ok(t, err)
+	assert.Cond(t, dcomp != nil, "expected a registered decompressor for method %d", customMethod)
+}